@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Aggregator 按某种输出格式对一批命令的结果文件做去重合并。Ingest 针对
+// 每个来源文件调用一次（其 lines 是该文件的全部行），这样 csv 等格式可以
+// 知道“这是某个文件的第一行”。Results 返回按首次出现顺序排列的去重结果。
+type Aggregator interface {
+	Ingest(lines []string) error
+	Results() []string
+}
+
+// newAggregator 按 format 构造对应的 Aggregator；未识别的 format 退化为
+// text 聚合器。dedupKey 仅被 jsonlAggregator 使用。
+func newAggregator(format, dedupKey string) Aggregator {
+	switch format {
+	case "jsonl":
+		return &jsonlAggregator{key: dedupKey, seen: make(map[string]struct{})}
+	case "csv":
+		return &csvAggregator{seen: make(map[string]struct{})}
+	case "url":
+		return &urlAggregator{seen: make(map[string]struct{})}
+	default:
+		return &textAggregator{seen: make(map[string]struct{})}
+	}
+}
+
+// textAggregator 是原有行为：按行去重，保留首次出现的顺序。
+type textAggregator struct {
+	seen   map[string]struct{}
+	result []string
+}
+
+func (a *textAggregator) Ingest(lines []string) error {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, ok := a.seen[line]; ok {
+			continue
+		}
+		a.seen[line] = struct{}{}
+		a.result = append(a.result, line)
+	}
+	return nil
+}
+
+func (a *textAggregator) Results() []string { return a.result }
+
+// jsonlAggregator 按 key 字段的值对 JSON 行去重；key 为空时退化为按整行
+// 文本去重。解析失败的行按整行文本去重后原样保留。
+type jsonlAggregator struct {
+	key    string
+	seen   map[string]struct{}
+	result []string
+}
+
+func (a *jsonlAggregator) Ingest(lines []string) error {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		dedupKey := line
+		if a.key != "" {
+			var obj map[string]interface{}
+			if err := json.Unmarshal([]byte(line), &obj); err == nil {
+				if v, ok := obj[a.key]; ok {
+					dedupKey = fmt.Sprintf("%v", v)
+				}
+			}
+		}
+		if _, ok := a.seen[dedupKey]; ok {
+			continue
+		}
+		a.seen[dedupKey] = struct{}{}
+		a.result = append(a.result, line)
+	}
+	return nil
+}
+
+func (a *jsonlAggregator) Results() []string { return a.result }
+
+// csvAggregator 认为每个来源文件的第一行是表头：记录第一个文件的表头，
+// 之后文件的表头行一律丢弃，数据行按完整行文本去重。
+type csvAggregator struct {
+	header string
+	seen   map[string]struct{}
+	result []string
+}
+
+func (a *csvAggregator) Ingest(lines []string) error {
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if i == 0 {
+			if a.header == "" {
+				a.header = line
+			}
+			continue
+		}
+		if _, ok := a.seen[line]; ok {
+			continue
+		}
+		a.seen[line] = struct{}{}
+		a.result = append(a.result, line)
+	}
+	return nil
+}
+
+func (a *csvAggregator) Results() []string {
+	if a.header == "" {
+		return a.result
+	}
+	return append([]string{a.header}, a.result...)
+}
+
+// urlAggregator 在去重前规范化每条 URL：小写 scheme/host，去掉默认端口
+// （http 的 80、https 的 443），去掉路径末尾多余的 "/"，并按参数名对
+// 查询字符串排序，这样 https://x.com:443/a 与 https://x.com/a、
+// 以及 ?a=1&b=2 与 ?b=2&a=1 都会被视为同一条结果。
+type urlAggregator struct {
+	seen   map[string]struct{}
+	result []string
+}
+
+// defaultPortForScheme 返回 scheme 对应的默认端口号（已小写），未知 scheme 返回 ""。
+func defaultPortForScheme(scheme string) string {
+	switch scheme {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	default:
+		return ""
+	}
+}
+
+func normalizeURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if port := u.Port(); port != "" && port == defaultPortForScheme(u.Scheme) {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+	u.Host = host
+	if u.Path != "/" {
+		u.Path = strings.TrimSuffix(u.Path, "/")
+	}
+	if u.RawQuery != "" {
+		values := u.Query()
+		u.RawQuery = values.Encode() // url.Values.Encode 按键排序
+	}
+	return u.String()
+}
+
+func (a *urlAggregator) Ingest(lines []string) error {
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		normalized := normalizeURL(line)
+		if _, ok := a.seen[normalized]; ok {
+			continue
+		}
+		a.seen[normalized] = struct{}{}
+		a.result = append(a.result, normalized)
+	}
+	return nil
+}
+
+func (a *urlAggregator) Results() []string { return a.result }
+
+// aggKey 标识一组共享同一个 Aggregator 实例的命令：format 相同还不够，
+// jsonlAggregator 的去重行为依赖 dedupKey，因此两者都不同时才能共用
+// 同一个聚合器，否则后出现的命令的 dedup_key 会被先出现的命令覆盖。
+type aggKey struct {
+	format   string
+	dedupKey string
+}
+
+// consolidateResults 按每个 Command 声明的 (format, dedup_key) 分组，用对应的
+// Aggregator 去重合并其结果文件；同一 format 下的各分组结果会再做一次
+// 整行去重后，写出 outputDir/consolidated_<format>.txt，并额外写出合并
+// 全部格式、全局去重的 outputDir/all_results.txt。
+func consolidateResults(outputDir string, commands []Command) error {
+	aggregators := make(map[aggKey]Aggregator)
+	var keyOrder []aggKey
+	var formatOrder []string
+	seenFormat := make(map[string]bool)
+
+	for _, cmd := range commands {
+		format := cmd.Format
+		if format == "" {
+			format = "text"
+		}
+		key := aggKey{format: format, dedupKey: cmd.DedupKey}
+		agg, ok := aggregators[key]
+		if !ok {
+			agg = newAggregator(format, cmd.DedupKey)
+			aggregators[key] = agg
+			keyOrder = append(keyOrder, key)
+			if !seenFormat[format] {
+				seenFormat[format] = true
+				formatOrder = append(formatOrder, format)
+			}
+		}
+
+		sourceFilePath := filepath.Join(outputDir, cmd.Output)
+		if _, err := os.Stat(sourceFilePath); os.IsNotExist(err) {
+			log.Printf("警告: 未找到 '%s' 的结果文件 %s，已跳过。", cmd.Name, sourceFilePath)
+			continue
+		}
+
+		content, err := os.ReadFile(sourceFilePath)
+		if err != nil {
+			log.Printf("警告: 读取文件 %s 失败: %v", sourceFilePath, err)
+			continue
+		}
+
+		if err := agg.Ingest(strings.Split(string(content), "\n")); err != nil {
+			log.Printf("警告: 聚合 '%s' (%s 格式) 失败: %v", cmd.Name, format, err)
+		}
+	}
+
+	seenGlobal := make(map[string]struct{})
+	var allLines []string
+
+	for _, format := range formatOrder {
+		var results []string
+		seenFormatLine := make(map[string]struct{})
+		for _, key := range keyOrder {
+			if key.format != format {
+				continue
+			}
+			for _, line := range aggregators[key].Results() {
+				if _, ok := seenFormatLine[line]; ok {
+					continue
+				}
+				seenFormatLine[line] = struct{}{}
+				results = append(results, line)
+			}
+		}
+
+		perFormatPath := filepath.Join(outputDir, fmt.Sprintf("consolidated_%s.txt", format))
+		log.Printf("信息: 正在写出 %s 格式的汇总结果到 %s", format, perFormatPath)
+		if err := writeLines(perFormatPath, results); err != nil {
+			return fmt.Errorf("写出 %s 格式汇总结果失败: %w", format, err)
+		}
+
+		for _, line := range results {
+			if _, ok := seenGlobal[line]; ok {
+				continue
+			}
+			seenGlobal[line] = struct{}{}
+			allLines = append(allLines, line)
+		}
+	}
+
+	consolidatedFilePath := filepath.Join(outputDir, "all_results.txt")
+	log.Printf("信息: 正在汇总结果到 %s", consolidatedFilePath)
+	finalFile, err := os.Create(consolidatedFilePath)
+	if err != nil {
+		return fmt.Errorf("无法创建汇总文件: %w", err)
+	}
+	defer finalFile.Close()
+
+	finalFile.WriteString("\n\n--- 去重后的所有结果 ---\n\n")
+	for _, line := range allLines {
+		finalFile.WriteString(line + "\n")
+	}
+
+	log.Printf("信息: 已成功汇总并去重所有结果。")
+	return nil
+}
+
+// writeLines 将 lines 逐行写入 path，覆盖已存在的文件。
+func writeLines(path string, lines []string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, line := range lines {
+		if _, err := f.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
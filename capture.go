@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"container/ring"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// tailBufferSize 是失败摘要中保留的每个命令的最后输出行数。
+const tailBufferSize = 20
+
+// Event 是写入 NDJSON 事件流的一条结构化记录。
+type Event struct {
+	Type       string `json:"type"`
+	Command    string `json:"command"`
+	Line       string `json:"line,omitempty"`
+	Stream     string `json:"stream,omitempty"`
+	ExitCode   int    `json:"exit_code,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Time       string `json:"time"`
+}
+
+// EventWriter 将 Event 以 NDJSON 格式写入共享的事件流文件，
+// 多个 worker 可并发调用 Write，内部通过互斥锁串行化写入。
+type EventWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewEventWriter 基于给定路径创建一个 EventWriter；path 为空时返回 nil，
+// 调用方应在 nil 的情况下跳过事件记录。
+func NewEventWriter(path string) (*EventWriter, io.Closer, error) {
+	if path == "" {
+		return nil, nil, nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("无法创建事件流文件 %s: %w", path, err)
+	}
+	return &EventWriter{w: f}, f, nil
+}
+
+// Write 将单个事件以 JSON 加换行的形式写入事件流。
+func (ew *EventWriter) Write(e Event) {
+	if ew == nil {
+		return
+	}
+	e.Time = time.Now().Format(time.RFC3339Nano)
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	ew.w.Write(data)
+	ew.w.Write([]byte("\n"))
+}
+
+// lineTail 是一个固定容量的环形缓冲区，保存命令输出的最后若干行。
+// stdout/stderr 各自的 streamCapture goroutine 会并发调用 add，因此用
+// 互斥锁保护 r 本身的读写。
+type lineTail struct {
+	mu sync.Mutex
+	r  *ring.Ring
+}
+
+func newLineTail(n int) *lineTail {
+	return &lineTail{r: ring.New(n)}
+}
+
+func (t *lineTail) add(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.r.Value = line
+	t.r = t.r.Next()
+}
+
+// lines 按照原始顺序返回缓冲区中的所有行。
+func (t *lineTail) lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []string
+	t.r.Do(func(v interface{}) {
+		if v != nil {
+			out = append(out, v.(string))
+		}
+	})
+	return out
+}
+
+// streamCapture 在独立 goroutine 中通过 bufio.Scanner 按行读取给定管道，
+// 将每一行写入日志文件、追加进尾部缓冲区，通过 reporter 上报（由其决定
+// 是回显到终端还是更新 TUI 面板），并在 events 不为 nil 时发出结构化
+// 事件。streamName 为 "stdout" 或 "stderr"。
+func streamCapture(slot int, name, streamName string, pipe io.Reader, logFile io.Writer, tail *lineTail, events *EventWriter, reporter Reporter) {
+	scanner := bufio.NewScanner(pipe)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fmt.Fprintln(logFile, line)
+		tail.add(line)
+		reporter.Line(slot, name, streamName, line)
+		events.Write(Event{Type: streamName + "_line", Command: name, Line: line, Stream: streamName})
+	}
+}
+
+// openCommandLog 在 outputDir/logs 下为 cmd 创建原始 .log 文件。
+func openCommandLog(outputDir, name string) (*os.File, error) {
+	logDir := filepath.Join(outputDir, "logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建日志目录失败: %w", err)
+	}
+	return os.Create(filepath.Join(logDir, name+".log"))
+}
@@ -0,0 +1,83 @@
+package main
+
+import "fmt"
+
+// commandGraph 保存命令间由 depends_on 构成的依赖关系，用于调度器按拓扑
+// 顺序派发任务。
+type commandGraph struct {
+	byName     map[string]Command
+	order      []string            // 任一合法的拓扑顺序，仅用于诊断输出
+	dependents map[string][]string // name -> 依赖它的下游命令
+	indegree   map[string]int      // name -> 尚未满足的依赖数
+}
+
+// buildCommandGraph 校验 depends_on 引用的命令均存在，并用 Kahn 算法检测
+// 循环依赖；若存在循环则在加载阶段立即返回错误。
+func buildCommandGraph(commands []Command) (*commandGraph, error) {
+	g := &commandGraph{
+		byName:     make(map[string]Command, len(commands)),
+		dependents: make(map[string][]string, len(commands)),
+		indegree:   make(map[string]int, len(commands)),
+	}
+
+	for _, cmd := range commands {
+		if _, dup := g.byName[cmd.Name]; dup {
+			return nil, fmt.Errorf("命令名称 '%s' 重复", cmd.Name)
+		}
+		g.byName[cmd.Name] = cmd
+		g.indegree[cmd.Name] = 0
+	}
+
+	for _, cmd := range commands {
+		for _, dep := range cmd.DependsOn {
+			if _, ok := g.byName[dep]; !ok {
+				return nil, fmt.Errorf("命令 '%s' 依赖了不存在的命令 '%s'", cmd.Name, dep)
+			}
+			g.dependents[dep] = append(g.dependents[dep], cmd.Name)
+			g.indegree[cmd.Name]++
+		}
+	}
+
+	// Kahn 算法：反复移除入度为 0 的节点，若最终无法覆盖所有节点则存在环。
+	remaining := make(map[string]int, len(g.indegree))
+	for name, d := range g.indegree {
+		remaining[name] = d
+	}
+	var queue []string
+	for _, cmd := range commands {
+		if remaining[cmd.Name] == 0 {
+			queue = append(queue, cmd.Name)
+		}
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		g.order = append(g.order, name)
+		for _, next := range g.dependents[name] {
+			remaining[next]--
+			if remaining[next] == 0 {
+				queue = append(queue, next)
+			}
+		}
+	}
+	if len(g.order) != len(commands) {
+		return nil, fmt.Errorf("命令依赖关系中存在循环，无法调度: %s", cyclicNames(commands, g.order))
+	}
+
+	return g, nil
+}
+
+// cyclicNames 返回未能进入拓扑顺序的命令名，用于错误提示。
+func cyclicNames(commands []Command, order []string) string {
+	done := make(map[string]bool, len(order))
+	for _, name := range order {
+		done[name] = true
+	}
+	var stuck []string
+	for _, cmd := range commands {
+		if !done[cmd.Name] {
+			stuck = append(stuck, cmd.Name)
+		}
+	}
+	return fmt.Sprintf("%v", stuck)
+}
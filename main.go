@@ -1,26 +1,37 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
+// killGrace 是命令超时后，从发送 SIGTERM 到强制 SIGKILL 之间的等待时间。
+const killGrace = 5 * time.Second
+
 // Command 定义了 YAML 文件中单个命令的结构。
 // 注意：YAML 标签已修正为标准格式。
 type Command struct {
-	Name   string `yaml:"name"`
-	Cmd    string `yaml:"cmd"`
-	Output string `yaml:"output"`
+	Name         string            `yaml:"name"`
+	Cmd          string            `yaml:"cmd"`
+	Output       string            `yaml:"output"`
+	Timeout      string            `yaml:"timeout"`       // 如 "30s"，为空则使用全局 -timeout
+	Retries      int               `yaml:"retries"`       // 失败后的额外重试次数
+	RetryBackoff string            `yaml:"retry_backoff"` // 如 "2s"，重试之间的等待时间
+	Inputs       map[string]string `yaml:"inputs"`        // 命名的文件引用，如 subs: subs.txt
+	DependsOn    []string          `yaml:"depends_on"`    // 必须先成功执行的命令名
+	Format       string            `yaml:"format"`        // 结果文件格式: text(默认)/jsonl/csv/url
+	DedupKey     string            `yaml:"dedup_key"`     // format 为 jsonl 时，用于去重的字段名
 }
 
 // Config 持有一系列命令。
@@ -30,8 +41,12 @@ type Config struct {
 
 // JobResult 保存单个命令的执行结果。
 type JobResult struct {
-	Command Command
-	Err     error
+	Command  Command
+	Err      error
+	Tail     []string // 失败时捕获的最后 tailBufferSize 行输出，用于摘要展示
+	Attempts int      // 实际执行的尝试次数（含首次运行）
+	ExitCode int      // 最后一次尝试的退出码，0 表示成功
+	Skipped  bool     // 因增量重跑或 -only/-skip 过滤而未实际执行
 }
 
 func main() {
@@ -41,16 +56,36 @@ func main() {
 	outputDir := flag.String("output", "results", "用于保存输出文件的目录。")
 	concurrency := flag.Int("c", 10, "并发运行的命令数量。")
 	clean := flag.Bool("clean", false, "运行时清理输出目录。")
+	eventsPath := flag.String("events", "", "可选的 NDJSON 结构化事件输出文件路径。")
+	timeout := flag.String("timeout", "", "单个命令的默认超时时间，如 '30s'（命令自身的 timeout 优先）。留空表示不限制。")
+	statePath := flag.String("state", "", "可选的任务状态文件路径，如 'runs/state.json'；启用后会跳过命令与输入均未变化且上次成功的命令。")
+	force := flag.Bool("force", false, "忽略 -state 中记录的状态，强制重新执行全部命令。")
+	only := flag.String("only", "", "逗号分隔的命令名列表，仅执行其中列出的命令，其余视为已跳过。")
+	skip := flag.String("skip", "", "逗号分隔的命令名列表，跳过其中列出的命令。")
+	tui := flag.Bool("tui", false, "启用实时进度面板（仅当标准输出连接到终端时生效，否则自动回退为普通日志输出）。")
 	flag.Parse()
 
 	log.SetFlags(log.Ltime) // 设置日志格式，输出时间
 
+	defaultTimeout, err := parseTimeout(*timeout)
+	if err != nil {
+		log.Fatalf("致命错误: 解析 -timeout 失败: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	// 2. 加载配置文件
 	cfg, err := loadConfig(*configPath)
 	if err != nil {
 		log.Fatalf("致命错误: 加载配置文件 '%s' 失败: %v", *configPath, err)
 	}
 
+	graph, err := buildCommandGraph(cfg.Commands)
+	if err != nil {
+		log.Fatalf("致命错误: 命令依赖关系非法: %v", err)
+	}
+
 	// 3. 根据 -clean 参数准备输出目录
 	if *clean {
 		log.Printf("信息: 检测到 '-clean' 参数，正在删除目录: %s", *outputDir)
@@ -62,35 +97,46 @@ func main() {
 		log.Fatalf("致命错误: 创建输出目录 '%s' 失败: %v", *outputDir, err)
 	}
 
-	// 4. 设置 Worker Pool 以控制并发
-	var wg sync.WaitGroup
-	jobs := make(chan Command, len(cfg.Commands))
-	results := make(chan JobResult, len(cfg.Commands))
-
-	log.Printf("信息: 启动 %d 个 Worker 处理 %d 个命令。", *concurrency, len(cfg.Commands))
-
-	for i := 1; i <= *concurrency; i++ {
-		wg.Add(1)
-		go worker(i, &wg, jobs, results, *domainsPath, *outputDir)
+	events, eventsCloser, err := NewEventWriter(*eventsPath)
+	if err != nil {
+		log.Fatalf("致命错误: %v", err)
+	}
+	if eventsCloser != nil {
+		defer eventsCloser.Close()
 	}
 
-	// 5. 将所有任务推送到任务管道
-	for _, cmd := range cfg.Commands {
-		jobs <- cmd
+	state, err := LoadStateStore(*statePath)
+	if err != nil {
+		log.Fatalf("致命错误: 加载状态文件 '%s' 失败: %v", *statePath, err)
 	}
-	close(jobs)
 
-	// 6. 等待所有 Worker 完成并关闭结果管道
-	wg.Wait()
-	close(results)
+	// 4. 按依赖图调度执行，就绪集合内部仍以 -c 控制并发
+	log.Printf("信息: 以并发度 %d 调度 %d 个命令（按依赖关系排序）。", *concurrency, len(cfg.Commands))
+	reporter := newReporter(*tui, *concurrency)
+	results := runGraph(ctx, graph, schedulerOptions{
+		DomainsPath:    *domainsPath,
+		OutputDir:      *outputDir,
+		Events:         events,
+		Concurrency:    *concurrency,
+		DefaultTimeout: defaultTimeout,
+		State:          state,
+		Force:          *force,
+		Only:           parseNameSet(*only),
+		Skip:           parseNameSet(*skip),
+		Reporter:       reporter,
+	})
 
 	// 7. 处理并报告结果
 	var failedCommands []JobResult
+	skippedCount := 0
 	for result := range results {
 		if result.Err != nil {
 			failedCommands = append(failedCommands, result)
+		} else if result.Skipped {
+			skippedCount++
 		}
 	}
+	reporter.Close()
 
 	log.Println("信息: 所有命令已执行完毕。")
 
@@ -103,29 +149,80 @@ func main() {
 	log.Println("--- 执行摘要 ---")
 	log.Printf("总命令数: %d", len(cfg.Commands))
 	log.Printf("成功: %d", len(cfg.Commands)-len(failedCommands))
+	log.Printf("  其中跳过 (增量重跑/-only/-skip): %d", skippedCount)
 	log.Printf("失败: %d", len(failedCommands))
 	if len(failedCommands) > 0 {
 		log.Println("失败命令详情:")
 		for _, failed := range failedCommands {
 			log.Printf("  - 名称: %s, 错误: %v", failed.Command.Name, failed.Err)
+			for _, line := range failed.Tail {
+				log.Printf("      | %s", line)
+			}
 		}
 	}
 	log.Println("--------------------")
 }
 
-// worker 是一个处理任务的 goroutine。
-func worker(id int, wg *sync.WaitGroup, jobs <-chan Command, results chan<- JobResult, domainsPath, outputDir string) {
-	defer wg.Done()
-	for cmd := range jobs {
-		log.Printf("WORKER %d: 开始执行 '%s'", id, cmd.Name)
-		err := runCommand(cmd, domainsPath, outputDir)
+// parseTimeout 解析超时字符串；空字符串表示不限制，返回 0。
+func parseTimeout(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// parseNameSet 把逗号分隔的命令名列表解析为集合；空字符串返回空集合。
+func parseNameSet(s string) map[string]struct{} {
+	set := make(map[string]struct{})
+	if s == "" {
+		return set
+	}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			set[name] = struct{}{}
+		}
+	}
+	return set
+}
+
+// runCommandWithRetries 按 cmd.Retries 声明的次数重试执行，每次重试前按
+// cmd.RetryBackoff 等待。ctx 被取消（如收到 SIGINT/SIGTERM）时立即停止重试。
+func runCommandWithRetries(ctx context.Context, cmd Command, domainsPath, outputDir string, events *EventWriter, defaultTimeout time.Duration, reporter Reporter, slot int) JobResult {
+	timeout := defaultTimeout
+	if cmd.Timeout != "" {
+		parsed, err := time.ParseDuration(cmd.Timeout)
+		if err != nil {
+			return JobResult{Command: cmd, Err: fmt.Errorf("无效的 timeout '%s': %w", cmd.Timeout, err)}
+		}
+		timeout = parsed
+	}
+	backoff := time.Duration(0)
+	if cmd.RetryBackoff != "" {
+		parsed, err := time.ParseDuration(cmd.RetryBackoff)
 		if err != nil {
-			log.Printf("WORKER %d: 执行 '%s' 失败: %v", id, cmd.Name, err)
-		} else {
-			log.Printf("WORKER %d: 完成 '%s'", id, cmd.Name)
+			return JobResult{Command: cmd, Err: fmt.Errorf("无效的 retry_backoff '%s': %w", cmd.RetryBackoff, err)}
 		}
-		results <- JobResult{Command: cmd, Err: err}
+		backoff = parsed
 	}
+
+	var result JobResult
+	for attempt := 1; attempt <= cmd.Retries+1; attempt++ {
+		result = runCommand(ctx, cmd, domainsPath, outputDir, events, timeout, reporter, slot)
+		result.Attempts = attempt
+		if result.Err == nil || ctx.Err() != nil {
+			return result
+		}
+		if attempt <= cmd.Retries {
+			log.Printf("信息: '%s' 第 %d 次尝试失败，%s 后重试", cmd.Name, attempt, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return result
+			}
+		}
+	}
+	return result
 }
 
 // loadConfig 读取并解析 YAML 配置文件。
@@ -141,68 +238,102 @@ func loadConfig(path string) (*Config, error) {
 	return &cfg, nil
 }
 
-// runCommand 执行单个 shell 命令。
-func runCommand(cmd Command, domainsPath, outputDir string) error {
-	fullOutputPath := filepath.Join(outputDir, cmd.Output)
-	cmdStr := strings.ReplaceAll(cmd.Cmd, "{domains}", domainsPath)
-	cmdStr = strings.ReplaceAll(cmdStr, "{output}", fullOutputPath)
+// runCommand 执行单个 shell 命令，将其 stdout/stderr 按行流式捕获到
+// outputDir/logs/<name>.log，并在 events 不为 nil 时发出结构化事件。timeout
+// 为 0 表示不限制；超时或 ctx 被取消时，整个进程组先收到 SIGTERM，若在
+// killGrace 内未退出，整个进程组（而非仅 bash 本身）会被 SIGKILL。
+func runCommand(ctx context.Context, cmd Command, domainsPath, outputDir string, events *EventWriter, timeout time.Duration, reporter Reporter, slot int) JobResult {
+	cmdStr, err := renderCommand(cmd, domainsPath, outputDir)
+	if err != nil {
+		return JobResult{Command: cmd, Err: err}
+	}
 
-	// 使用 buffer 捕获输出，以便在出错时提供更详细的日志
-	var stderrBuf bytes.Buffer
-	c := exec.Command("bash", "-c", cmdStr)
-	c.Stdout = os.Stdout // 实时输出到终端
-	c.Stderr = io.MultiWriter(os.Stderr, &stderrBuf)
+	runCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	err := c.Run()
+	logFile, err := openCommandLog(outputDir, cmd.Name)
 	if err != nil {
-		return fmt.Errorf("执行失败: %v\n--- 错误输出 ---\n%s", err, stderrBuf.String())
+		return JobResult{Command: cmd, Err: err}
 	}
-	return nil
-}
-
-// consolidateResults 将所有单个输出文件合并为一个，并对所有内容进行去重。
-func consolidateResults(outputDir string, commands []Command) error {
-	consolidatedFilePath := filepath.Join(outputDir, "all_results.txt")
-	finalFile, err := os.Create(consolidatedFilePath)
+	defer logFile.Close()
+
+	c := exec.CommandContext(runCtx, "bash", "-c", cmdStr)
+	// 将子进程放入独立进程组，这样超时/取消时可以把信号发给整个组
+	// （包括 bash fork 出的子进程，如示例中的 sleep），而不仅仅是 bash 本身。
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	// exec.Cmd 自带的 WaitDelay 升级只会 Kill() 主进程本身，如果子进程
+	// trap 了 SIGTERM 并把孙子进程留在后台，孙子进程不会被杀死而成为孤儿。
+	// 因此这里不依赖 WaitDelay 的自动升级，而是在 Cancel 里自行安排一次
+	// 同样发给整个进程组的 SIGKILL。
+	done := make(chan struct{})
+	c.Cancel = func() error {
+		pid := c.Process.Pid
+		syscall.Kill(-pid, syscall.SIGTERM)
+		go func() {
+			select {
+			case <-time.After(killGrace):
+				syscall.Kill(-pid, syscall.SIGKILL)
+			case <-done:
+			}
+		}()
+		return nil
+	}
+	c.WaitDelay = killGrace
+	stdoutPipe, err := c.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("无法创建汇总文件: %w", err)
+		return JobResult{Command: cmd, Err: fmt.Errorf("创建 stdout 管道失败: %w", err)}
+	}
+	stderrPipe, err := c.StderrPipe()
+	if err != nil {
+		return JobResult{Command: cmd, Err: fmt.Errorf("创建 stderr 管道失败: %w", err)}
 	}
-	defer finalFile.Close()
 
-	log.Printf("信息: 正在汇总结果到 %s", consolidatedFilePath)
+	tail := newLineTail(tailBufferSize)
+	events.Write(Event{Type: "start", Command: cmd.Name})
+	start := time.Now()
 
-	uniqueLines := make(map[string]struct{})
-	for _, cmd := range commands {
-		sourceFilePath := filepath.Join(outputDir, cmd.Output)
+	if err := c.Start(); err != nil {
+		return JobResult{Command: cmd, Err: fmt.Errorf("启动命令失败: %w", err)}
+	}
 
-		if _, err := os.Stat(sourceFilePath); os.IsNotExist(err) {
-			log.Printf("警告: 未找到 '%s' 的结果文件 %s，已跳过。", cmd.Name, sourceFilePath)
-			continue
+	var streamWg sync.WaitGroup
+	streamWg.Add(2)
+	go func() {
+		defer streamWg.Done()
+		streamCapture(slot, cmd.Name, "stdout", stdoutPipe, logFile, tail, events, reporter)
+	}()
+	go func() {
+		defer streamWg.Done()
+		streamCapture(slot, cmd.Name, "stderr", stderrPipe, logFile, tail, events, reporter)
+	}()
+	streamWg.Wait()
+
+	err = c.Wait()
+	close(done)
+	duration := time.Since(start)
+	exitCode := 0
+	if err != nil {
+		exitCode = -1
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
 		}
+	}
+	events.Write(Event{Type: "exit", Command: cmd.Name, ExitCode: exitCode, DurationMs: duration.Milliseconds()})
 
-		content, err := os.ReadFile(sourceFilePath)
-		if err != nil {
-			log.Printf("警告: 读取文件 %s 失败: %v", sourceFilePath, err)
-			continue
+	if err != nil {
+		if runCtx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("超时 (%s) 后被终止: %w", timeout, err)
 		}
-
-		lines := strings.Split(string(content), "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-			uniqueLines[line] = struct{}{}
+		return JobResult{
+			Command:  cmd,
+			Err:      fmt.Errorf("执行失败: %v\n--- 最后 %d 行输出 ---\n%s", err, tailBufferSize, strings.Join(tail.lines(), "\n")),
+			Tail:     tail.lines(),
+			ExitCode: exitCode,
 		}
 	}
-
-	// 输出所有唯一行到文件
-	finalFile.WriteString("\n\n--- 去重后的所有结果 ---\n\n")
-	for line := range uniqueLines {
-		finalFile.WriteString(line + "\n")
-	}
-
-	log.Printf("信息: 已成功汇总并去重所有结果。")
-	return nil
+	return JobResult{Command: cmd, ExitCode: exitCode}
 }
-
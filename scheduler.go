@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// schedulerOptions 汇集 runGraph 调度一次运行所需的全部配置，避免随着功能
+// 增加参数列表无限增长。
+type schedulerOptions struct {
+	DomainsPath    string
+	OutputDir      string
+	Events         *EventWriter
+	Concurrency    int
+	DefaultTimeout time.Duration
+	State          *StateStore         // 为 nil 时不做增量重跑判断
+	Force          bool                // 忽略状态存储，始终重新执行
+	Only           map[string]struct{} // 非空时，只执行其中列出的命令
+	Skip           map[string]struct{} // 列出的命令始终跳过
+	Reporter       Reporter            // 接收开始/完成/输出行事件
+}
+
+// errDependencySkipped 构造因上游依赖失败或未被实际执行而跳过某命令时使用的错误。
+func errDependencySkipped(name string) error {
+	return fmt.Errorf("已跳过 '%s': 其上游依赖未能成功执行或未被实际运行", name)
+}
+
+// decideSkip 判断某命令本次运行是否应当跳过实际执行，并给出原因；
+// 优先级: -skip > -only > 增量状态对比。增量状态对比命中后还会用
+// outputExists 确认 cmd.Output 仍然存在——状态文件记录的是上次成功的
+// 指纹，但 -clean 等操作可能已经把产出目录清空，此时即便指纹匹配也不能
+// 信任缓存，必须重新执行。verified 仅在增量状态对比命中且产出确实存在时为
+// true，表示该命令此前已被证实成功执行过，其产出可以被下游信任；
+// 因 -skip/-only 被排除的命令从未被验证过，verified 始终为 false，
+// 下游依赖它的命令应被当作“上游未运行”而跳过，而不是当作“上游已成功”放行。
+func decideSkip(cmd Command, opts schedulerOptions) (reason string, skip bool, verified bool) {
+	if _, excluded := opts.Skip[cmd.Name]; excluded {
+		return "位于 -skip 列表中", true, false
+	}
+	if len(opts.Only) > 0 {
+		if _, included := opts.Only[cmd.Name]; !included {
+			return "未出现在 -only 列表中", true, false
+		}
+	}
+	if opts.Force || opts.State == nil {
+		return "", false, false
+	}
+	hash, err := computeCommandHash(cmd, opts.DomainsPath, opts.OutputDir)
+	if err != nil {
+		log.Printf("警告: 计算 '%s' 的状态指纹失败，将正常执行: %v", cmd.Name, err)
+		return "", false, false
+	}
+	entry, ok := opts.State.Get(cmd.Name)
+	if !ok || entry.Status != "success" || entry.Hash != hash {
+		return "", false, false
+	}
+	if !outputExists(cmd, opts.OutputDir) {
+		log.Printf("信息: '%s' 的状态记录为成功，但产出文件已缺失（如经过 -clean），将重新执行", cmd.Name)
+		return "", false, false
+	}
+	return "命令与输入未发生变化，且上次已成功执行", true, true
+}
+
+// outputExists 判断 cmd 声明的 output 文件当前是否存在；cmd.Output 为空
+// 时视为不适用增量跳过判断的约束，直接返回 true。
+func outputExists(cmd Command, outputDir string) bool {
+	if cmd.Output == "" {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(outputDir, cmd.Output))
+	return err == nil
+}
+
+// recordState 在命令实际执行后，把其最新状态写入状态存储（如果启用）。
+func recordState(cmd Command, opts schedulerOptions, ok bool) {
+	if opts.State == nil {
+		return
+	}
+	hash, err := computeCommandHash(cmd, opts.DomainsPath, opts.OutputDir)
+	if err != nil {
+		log.Printf("警告: 计算 '%s' 的状态指纹失败，本次结果未持久化: %v", cmd.Name, err)
+		return
+	}
+	status := "failed"
+	if ok {
+		status = "success"
+	}
+	if err := opts.State.Set(cmd.Name, StateEntry{Hash: hash, Status: status, UpdatedAt: time.Now()}); err != nil {
+		log.Printf("警告: 写入状态文件失败: %v", err)
+	}
+}
+
+// runGraph 按依赖图调度命令执行：只有当一个命令的全部 depends_on 都已成功，
+// 它才会进入可运行队列；队列内的命令通过 opts.Concurrency 个编号槽位限制
+// 并发，槽位编号会上报给 opts.Reporter 供 TUI 按行展示。若某个命令失败，
+// 其全部下游命令都会被标记为跳过而不会执行。命令在开始前会先经过
+// decideSkip 判断是否可以跳过实际执行。
+func runGraph(ctx context.Context, g *commandGraph, opts schedulerOptions) <-chan JobResult {
+	results := make(chan JobResult, len(g.byName))
+	total := len(g.byName)
+	opts.Reporter.SetTotal(total)
+
+	slots := make(chan int, opts.Concurrency)
+	for i := 0; i < opts.Concurrency; i++ {
+		slots <- i
+	}
+
+	var mu sync.Mutex
+	remaining := make(map[string]int, len(g.indegree))
+	for name, d := range g.indegree {
+		remaining[name] = d
+	}
+
+	var wg sync.WaitGroup
+	var dispatch func(name string)
+	var onDone func(name string, ok bool)
+	var skip func(name string)
+
+	dispatch = func(name string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cmd := g.byName[name]
+
+			if reason, skipRun, verified := decideSkip(cmd, opts); skipRun {
+				log.Printf("信息: 跳过 '%s': %s", cmd.Name, reason)
+				results <- JobResult{Command: cmd, Skipped: true}
+				opts.Reporter.SlotDone(-1, cmd.Name, verified)
+				onDone(name, verified)
+				return
+			}
+
+			slot := <-slots
+			opts.Reporter.SlotStart(slot, cmd.Name)
+			result := runCommandWithRetries(ctx, cmd, opts.DomainsPath, opts.OutputDir, opts.Events, opts.DefaultTimeout, opts.Reporter, slot)
+			opts.Reporter.SlotDone(slot, cmd.Name, result.Err == nil)
+			slots <- slot
+
+			if result.Err != nil {
+				log.Printf("信息: 执行 '%s' 失败 (尝试 %d 次): %v", cmd.Name, result.Attempts, result.Err)
+			} else {
+				log.Printf("信息: 完成 '%s' (尝试 %d 次)", cmd.Name, result.Attempts)
+			}
+			recordState(cmd, opts, result.Err == nil)
+			results <- result
+			onDone(name, result.Err == nil)
+		}()
+	}
+
+	onDone = func(name string, ok bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, next := range g.dependents[name] {
+			if !ok {
+				skip(next)
+				continue
+			}
+			remaining[next]--
+			if remaining[next] == 0 {
+				dispatch(next)
+			}
+		}
+	}
+
+	skip = func(name string) {
+		if remaining[name] < 0 {
+			return // 已被标记跳过
+		}
+		remaining[name] = -1
+		log.Printf("信息: 跳过 '%s'，因其上游依赖未能成功执行或未被实际运行", name)
+		results <- JobResult{Command: g.byName[name], Err: errDependencySkipped(name)}
+		opts.Reporter.SlotDone(-1, name, false)
+		for _, next := range g.dependents[name] {
+			skip(next)
+		}
+	}
+
+	for name, d := range g.indegree {
+		if d == 0 {
+			dispatch(name)
+		}
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	return results
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StateEntry 记录某个命令最近一次执行的状态，用于增量重跑时判断是否可以跳过。
+type StateEntry struct {
+	Hash      string    `json:"hash"`
+	Status    string    `json:"status"` // "success" 或 "failed"
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StateStore 是持久化到磁盘的 JSON 任务状态存储，key 为命令名称。
+type StateStore struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]StateEntry
+}
+
+// LoadStateStore 从 path 读取状态文件；path 为空或文件不存在时返回一个
+// 空的、仍可正常使用的 StateStore（Save 对空 path 是空操作）。
+func LoadStateStore(path string) (*StateStore, error) {
+	s := &StateStore{path: path, entries: make(map[string]StateEntry)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Get 返回命令当前记录的状态。
+func (s *StateStore) Get(name string) (StateEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[name]
+	return e, ok
+}
+
+// Set 更新命令的状态并立即持久化到磁盘。
+func (s *StateStore) Set(name string, entry StateEntry) error {
+	s.mu.Lock()
+	s.entries[name] = entry
+	data, err := json.MarshalIndent(s.entries, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if s.path == "" {
+		return nil
+	}
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(s.path, data, 0644)
+}
+
+// computeCommandHash 汇总命令定义本身以及其依赖文件（显式 inputs 加上全局
+// domains 文件）的修改时间，生成一个指纹；只要命令或其输入发生变化，
+// 指纹就会改变，从而触发重新执行。
+func computeCommandHash(cmd Command, domainsPath, outputDir string) (string, error) {
+	h := sha256.New()
+
+	def, err := json.Marshal(cmd)
+	if err != nil {
+		return "", err
+	}
+	h.Write(def)
+
+	writeFileFingerprint(h, domainsPath)
+
+	names := make([]string, 0, len(cmd.Inputs))
+	for name := range cmd.Inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		writeFileFingerprint(h, filepath.Join(outputDir, cmd.Inputs[name]))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeFileFingerprint 把文件的修改时间（或其缺失状态）写入 hasher。
+func writeFileFingerprint(h interface{ Write([]byte) (int, error) }, path string) {
+	info, err := os.Stat(path)
+	if err != nil {
+		h.Write([]byte("missing:" + path))
+		return
+	}
+	h.Write([]byte(path + ":" + info.ModTime().String()))
+}
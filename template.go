@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// templateData 是渲染 cmd.Cmd 时提供给 {{ .Var }} 占位符的上下文。
+type templateData struct {
+	Domains string
+	Output  string
+	Input   map[string]string
+}
+
+// renderCommand 将 cmd.Cmd 渲染为最终可执行的 shell 命令字符串。为兼容
+// 旧配置，先处理历史遗留的 {domains}/{output} 占位符，再用 text/template
+// 解析 {{ .Domains }}、{{ .Output }}、{{ .Input.<name> }} 等占位符；
+// cmd.Inputs 中声明的命名文件引用会被拼接到 outputDir 下再注入模板。
+func renderCommand(cmd Command, domainsPath, outputDir string) (string, error) {
+	fullOutputPath := filepath.Join(outputDir, cmd.Output)
+
+	cmdStr := strings.ReplaceAll(cmd.Cmd, "{domains}", domainsPath)
+	cmdStr = strings.ReplaceAll(cmdStr, "{output}", fullOutputPath)
+
+	inputs := make(map[string]string, len(cmd.Inputs))
+	for name, path := range cmd.Inputs {
+		inputs[name] = filepath.Join(outputDir, path)
+	}
+
+	tmpl, err := template.New(cmd.Name).Option("missingkey=error").Parse(cmdStr)
+	if err != nil {
+		return "", fmt.Errorf("解析命令 '%s' 的模板失败: %w", cmd.Name, err)
+	}
+
+	var rendered strings.Builder
+	data := templateData{Domains: domainsPath, Output: fullOutputPath, Input: inputs}
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", fmt.Errorf("渲染命令 '%s' 的模板失败: %w", cmd.Name, err)
+	}
+
+	return rendered.String(), nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reporter 接收调度过程中的状态变化。runCommand/scheduler 通过它上报事件，
+// 而不直接关心这些事件最终是打印一行日志还是刷新一块终端面板。
+type Reporter interface {
+	SetTotal(total int)
+	Line(slot int, cmdName, stream, line string)
+	SlotStart(slot int, cmdName string)
+	SlotDone(slot int, cmdName string, ok bool)
+	Close()
+}
+
+// logReporter 还原了引入 TUI 之前的行为：把每一行输出原样回显到
+// os.Stdout/os.Stderr；开始/完成信息仍由调度器自己用 log.Printf 打印。
+type logReporter struct{}
+
+func (logReporter) Line(_ int, cmdName, stream, line string) {
+	w := os.Stdout
+	if stream == "stderr" {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "[%s] %s\n", cmdName, line)
+}
+
+// SetTotal/SlotStart/SlotDone 对 logReporter 是空操作：调度器自身已经用
+// log.Printf 打印了带重试次数的开始/完成信息，这里不需要重复。
+func (logReporter) SetTotal(_ int)                   {}
+func (logReporter) SlotStart(_ int, _ string)        {}
+func (logReporter) SlotDone(_ int, _ string, _ bool) {}
+
+func (logReporter) Close() {}
+
+// isTerminal 判断 f 是否连接到一个字符设备（终端），而不是文件或管道。
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// slotState 是仪表盘单个工作行的可变状态。
+type slotState struct {
+	cmdName  string
+	lastLine string
+	start    time.Time
+	active   bool
+}
+
+// dashboard 是一个手写的 ANSI 实时进度面板：每个并发槽位一行，显示当前
+// 命令名、耗时和最后一行输出，加上一条全局进度条（完成/总数、预计剩余
+// 时间、失败数）。
+type dashboard struct {
+	mu        sync.Mutex
+	slots     []slotState
+	total     int
+	done      int
+	failed    int
+	durations []time.Duration
+	stop      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// newDashboard 创建并启动一个仪表盘，concurrency 决定展示的槽位行数。
+func newDashboard(concurrency int) *dashboard {
+	d := &dashboard{
+		slots: make([]slotState, concurrency),
+		stop:  make(chan struct{}),
+	}
+	d.wg.Add(1)
+	go d.loop()
+	return d
+}
+
+func (d *dashboard) loop() {
+	defer d.wg.Done()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	linesDrawn := 0
+	for {
+		select {
+		case <-ticker.C:
+			linesDrawn = d.render(linesDrawn)
+		case <-d.stop:
+			d.render(linesDrawn)
+			return
+		}
+	}
+}
+
+func (d *dashboard) render(prevLines int) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if prevLines > 0 {
+		fmt.Printf("\x1b[%dA\x1b[J", prevLines) // 光标上移并清除之前绘制的区域
+	}
+
+	lines := 0
+	for i, s := range d.slots {
+		if s.active {
+			elapsed := time.Since(s.start).Round(time.Second)
+			fmt.Printf("[%2d] %-20s %6s  %s\n", i, truncate(s.cmdName, 20), elapsed, truncate(s.lastLine, 60))
+		} else {
+			fmt.Printf("[%2d] %-20s\n", i, "-")
+		}
+		lines++
+	}
+
+	eta := d.estimateETA()
+	fmt.Printf("进度: %d/%d 完成, %d 失败  预计剩余: %s\n", d.done, d.total, d.failed, eta)
+	lines++
+
+	return lines
+}
+
+func (d *dashboard) estimateETA() string {
+	remaining := d.total - d.done
+	if remaining <= 0 || len(d.durations) == 0 {
+		return "-"
+	}
+	var sum time.Duration
+	for _, dur := range d.durations {
+		sum += dur
+	}
+	avg := sum / time.Duration(len(d.durations))
+	activeSlots := 0
+	for _, s := range d.slots {
+		if s.active {
+			activeSlots++
+		}
+	}
+	if activeSlots == 0 {
+		activeSlots = 1
+	}
+	return (avg * time.Duration(remaining) / time.Duration(activeSlots)).Round(time.Second).String()
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
+
+func (d *dashboard) Line(slot int, cmdName, _ string, line string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if slot >= 0 && slot < len(d.slots) {
+		d.slots[slot].lastLine = line
+	}
+}
+
+// SetTotal 设置本次运行的命令总数，应在调度开始前调用一次，这样即使
+// 全部命令都被跳过（如增量重跑命中或 -only/-skip 过滤），进度行也能
+// 显示正确的总数，而不是停留在 "0/0"。
+func (d *dashboard) SetTotal(total int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.total = total
+}
+
+func (d *dashboard) SlotStart(slot int, cmdName string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if slot >= 0 && slot < len(d.slots) {
+		d.slots[slot] = slotState{cmdName: cmdName, start: time.Now(), active: true}
+	}
+}
+
+func (d *dashboard) SlotDone(slot int, cmdName string, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.done++
+	if !ok {
+		d.failed++
+	}
+	if slot >= 0 && slot < len(d.slots) && d.slots[slot].active {
+		d.durations = append(d.durations, time.Since(d.slots[slot].start))
+		d.slots[slot].active = false
+	}
+}
+
+func (d *dashboard) Close() {
+	close(d.stop)
+	d.wg.Wait()
+}
+
+// newReporter 在 tui 为 true 且 os.Stdout 连接到终端时返回一个 dashboard，
+// 否则回退到原有的逐行日志输出。
+func newReporter(tui bool, concurrency int) Reporter {
+	if tui && isTerminal(os.Stdout) {
+		return newDashboard(concurrency)
+	}
+	return logReporter{}
+}